@@ -89,6 +89,45 @@ type EngineFileSize struct {
 	IsImporting bool
 }
 
+// EngineConfig carries the per-engine options used when opening and
+// importing an engine. Unlike the global Lightning configuration, these
+// values may differ from one engine to another, e.g. an index engine may
+// want to be compacted before import while a data engine does not.
+type EngineConfig struct {
+	// TableName is the fully qualified name of the table this engine
+	// belongs to, in the form "`db`.`table`".
+	TableName string
+
+	// RegionSplitSize is the desired size (in bytes) of each region
+	// produced when this engine is imported into TiKV. Zero means the
+	// backend should fall back to its own default.
+	RegionSplitSize int64
+
+	// RegionSplitKeys is the desired number of keys of each region
+	// produced when this engine is imported into TiKV. Zero means the
+	// backend should fall back to its own default.
+	RegionSplitKeys int64
+
+	// IsIndexEngine marks this engine as holding only generated index KVs
+	// instead of the table's row data.
+	IsIndexEngine bool
+
+	// Local holds options specific to the local backend.
+	Local LocalEngineConfig
+}
+
+// LocalEngineConfig holds engine options which are only meaningful to the
+// local backend.
+type LocalEngineConfig struct {
+	// Compact forces the engine's SST files to be compacted before they
+	// are split and ingested into TiKV.
+	Compact bool
+
+	// DuplicateDetection enables scanning the engine for duplicate index
+	// keys while it is being ingested; see ErrDuplicateDetected.
+	DuplicateDetection bool
+}
+
 // AbstractBackend is the abstract interface behind Backend.
 // Implementations of this interface must be goroutine safe: you can share an
 // instance and execute any method anywhere.
@@ -113,26 +152,28 @@ type AbstractBackend interface {
 	// NewEncoder creates an encoder of a TiDB table.
 	NewEncoder(tbl table.Table, options *SessionOptions) Encoder
 
-	OpenEngine(ctx context.Context, engineUUID uuid.UUID) error
+	OpenEngine(ctx context.Context, engineUUID uuid.UUID, config *EngineConfig) error
 
-	WriteRows(
-		ctx context.Context,
-		engineUUID uuid.UUID,
-		tableName string,
-		columnNames []string,
-		commitTS uint64,
-		rows Rows,
-	) error
+	// LocalWriter obtains a writer for writing rows into the given engine.
+	// The writer should be reused by a single chunk-writing goroutine for as
+	// long as that chunk is being processed, so concurrent chunks of the
+	// same engine do not serialize on a shared method call. maxCacheSize
+	// bounds how much unflushed data the writer may buffer in memory before
+	// forcing a local flush; zero means use the backend's own default.
+	LocalWriter(ctx context.Context, engineUUID uuid.UUID, maxCacheSize int64) (EngineWriter, error)
 
 	CloseEngine(ctx context.Context, engineUUID uuid.UUID) error
 
-	ImportEngine(ctx context.Context, engineUUID uuid.UUID) error
+	// ImportEngine imports the content of the closed engine into the target,
+	// splitting it into regions of roughly regionSplitSize bytes and
+	// regionSplitKeys keys beforehand.
+	ImportEngine(ctx context.Context, engineUUID uuid.UUID, regionSplitSize, regionSplitKeys int64) error
 
 	CleanupEngine(ctx context.Context, engineUUID uuid.UUID) error
 
 	// CheckRequirements performs the check whether the backend satisfies the
 	// version requirements
-	CheckRequirements(ctx context.Context) error
+	CheckRequirements(ctx context.Context, checkCtx *CheckCtx) error
 
 	// FetchRemoteTableModels obtains the models of all tables given the schema
 	// name. The returned table info does not need to be precise if the encoder,
@@ -150,12 +191,31 @@ type AbstractBackend interface {
 
 	// FlushEngine ensures all KV pairs written to an open engine has been
 	// synchronized, such that kill-9'ing Lightning afterwards and resuming from
-	// checkpoint can recover the exact same content.
+	// checkpoint can recover the exact same content. Any chunk offsets
+	// recorded via RecordChunkCheckpoint since the last flush must be fsync'd
+	// atomically with the KV writes, so that the recovered offset never
+	// points past what was actually persisted.
 	//
 	// This method is only relevant for local backend, and is no-op for all
 	// other backends.
 	FlushEngine(engineUUID uuid.UUID) error
 
+	// GetWrittenKeyRange returns the inclusive-exclusive range of user keys
+	// already written to engineUUID. It can return nil, nil if the engine
+	// has not been written to yet.
+	GetWrittenKeyRange(engineUUID uuid.UUID) (startKey, endKey []byte, err error)
+
+	// SeekWriteOffset returns the byte offset inside chunkID up to which
+	// data has already been durably written to engineUUID, so that a chunk
+	// can be resumed instead of replayed from the start after a restart.
+	// found is false if no checkpoint was recorded for this chunk.
+	SeekWriteOffset(engineUUID uuid.UUID, chunkID string) (offset int64, found bool, err error)
+
+	// RecordChunkCheckpoint persists the byte offset reached so far while
+	// writing chunkID into engineUUID. The offset only becomes durable once
+	// FlushEngine is called.
+	RecordChunkCheckpoint(engineUUID uuid.UUID, chunkID string, offset int64) error
+
 	// FlushAllEngines performs FlushEngine on all opened engines. This is a
 	// very expensive operation and should only be used in some rare situation
 	// (e.g. preparing to resolve a disk quota violation).
@@ -168,6 +228,18 @@ type AbstractBackend interface {
 
 	// ResetEngine clears all written KV pairs in this opened engine.
 	ResetEngine(ctx context.Context, engineUUID uuid.UUID) error
+
+	// ResolveDuplicateRows re-queries the target TiDB cluster for the row
+	// identified by conflict.RowIDs and records it for later user action. It
+	// is only meaningful for backends which support duplicate detection.
+	ResolveDuplicateRows(ctx context.Context, conflict DuplicateConflict) error
+
+	// CollectDuplicateRows scans the closed engine identified by engineUUID
+	// for duplicate index keys (byte-equal user keys with differing values).
+	// It is called after ImportEngine returned ErrDuplicateDetected on a
+	// Backend that has a DuplicateManager, and should return every conflict
+	// found.
+	CollectDuplicateRows(ctx context.Context, engineUUID uuid.UUID) ([]DuplicateConflict, error)
 }
 
 func fetchRemoteTableModelsFromTLS(ctx context.Context, tls *common.TLS, schema string) ([]*model.TableInfo, error) {
@@ -181,13 +253,37 @@ func fetchRemoteTableModelsFromTLS(ctx context.Context, tls *common.TLS, schema
 
 // Backend is the delivery target for Lightning
 type Backend struct {
-	abstract AbstractBackend
+	abstract   AbstractBackend
+	dupMgr     DuplicateManager
+	targetInfo TargetInfoGetter
+}
+
+// BackendOpt configures optional behavior of a Backend constructed via
+// MakeBackend.
+type BackendOpt func(*Backend)
+
+// WithDuplicateManager makes the Backend persist and resolve duplicate
+// conflicts collected by the underlying AbstractBackend through mgr.
+func WithDuplicateManager(mgr DuplicateManager) BackendOpt {
+	return func(be *Backend) {
+		be.dupMgr = mgr
+	}
+}
+
+// WithTargetInfoGetter makes the Backend delegate CheckRequirements and
+// FetchRemoteTableModels to getter instead of the embedded AbstractBackend.
+func WithTargetInfoGetter(getter TargetInfoGetter) BackendOpt {
+	return func(be *Backend) {
+		be.targetInfo = getter
+	}
 }
 
 type engine struct {
 	backend AbstractBackend
+	dupMgr  DuplicateManager
 	logger  log.Logger
 	uuid    uuid.UUID
+	config  *EngineConfig
 }
 
 // OpenedEngine is an opened engine, allowing data to be written via WriteRows.
@@ -212,8 +308,12 @@ type ClosedEngine struct {
 	engine
 }
 
-func MakeBackend(ab AbstractBackend) Backend {
-	return Backend{abstract: ab}
+func MakeBackend(ab AbstractBackend, opts ...BackendOpt) Backend {
+	be := Backend{abstract: ab}
+	for _, opt := range opts {
+		opt(&be)
+	}
+	return be
 }
 
 func (be Backend) Close() {
@@ -232,11 +332,17 @@ func (be Backend) ShouldPostProcess() bool {
 	return be.abstract.ShouldPostProcess()
 }
 
-func (be Backend) CheckRequirements(ctx context.Context) error {
-	return be.abstract.CheckRequirements(ctx)
+func (be Backend) CheckRequirements(ctx context.Context, checkCtx *CheckCtx) error {
+	if be.targetInfo != nil {
+		return be.targetInfo.CheckRequirements(ctx, checkCtx)
+	}
+	return be.abstract.CheckRequirements(ctx, checkCtx)
 }
 
 func (be Backend) FetchRemoteTableModels(ctx context.Context, schemaName string) ([]*model.TableInfo, error) {
+	if be.targetInfo != nil {
+		return be.targetInfo.FetchRemoteTableModels(ctx, schemaName)
+	}
 	return be.abstract.FetchRemoteTableModels(ctx, schemaName)
 }
 
@@ -244,6 +350,21 @@ func (be Backend) FlushAll() error {
 	return be.abstract.FlushAllEngines()
 }
 
+// LocalWriter obtains a writer for streaming rows into engineUUID.
+// maxCacheSize bounds how much unflushed data the writer may buffer in
+// memory before forcing a local flush; zero means use the backend's own
+// default.
+func (be Backend) LocalWriter(ctx context.Context, engineUUID uuid.UUID, maxCacheSize int64) (EngineWriter, error) {
+	return be.abstract.LocalWriter(ctx, engineUUID, maxCacheSize)
+}
+
+// EngineFileSizes obtains the size occupied locally of all engines managed
+// by this backend. This method should be called periodically to trigger
+// ingest disk quota checks.
+func (be Backend) EngineFileSizes() []EngineFileSize {
+	return be.abstract.EngineFileSizes()
+}
+
 // CheckDiskQuota verifies if the total engine file size is below the given
 // quota. If the quota is exceeded, this method returns an array of engines,
 // which after importing can decrease the total size below quota.
@@ -273,14 +394,19 @@ func (be Backend) CheckDiskQuota(quota int64) (largeEngines []uuid.UUID, inProgr
 // into the target and then reset the engine to empty. This method will not
 // close the engine. Make sure the engine is flushed manually before calling
 // this method.
-func (be Backend) UnsafeImportAndReset(ctx context.Context, engineUUID uuid.UUID) error {
+func (be Backend) UnsafeImportAndReset(ctx context.Context, engineUUID uuid.UUID, config *EngineConfig) error {
 	// DO NOT call be.abstract.CloseEngine()! The engine should still be writable after
 	// calling UnsafeImportAndReset().
+	if config == nil {
+		config = &EngineConfig{}
+	}
 	closedEngine := ClosedEngine{
 		engine: engine{
 			backend: be.abstract,
+			dupMgr:  be.dupMgr,
 			logger:  makeLogger("<import-and-reset>", engineUUID),
 			uuid:    engineUUID,
+			config:  config,
 		},
 	}
 	if err := closedEngine.Import(ctx); err != nil {
@@ -290,11 +416,18 @@ func (be Backend) UnsafeImportAndReset(ctx context.Context, engineUUID uuid.UUID
 }
 
 // OpenEngine opens an engine with the given table name and engine ID.
-func (be Backend) OpenEngine(ctx context.Context, tableName string, engineID int32) (*OpenedEngine, error) {
+func (be Backend) OpenEngine(ctx context.Context, config *EngineConfig, tableName string, engineID int32) (*OpenedEngine, error) {
 	tag, engineUUID := MakeUUID(tableName, engineID)
 	logger := makeLogger(tag, engineUUID)
 
-	if err := be.abstract.OpenEngine(ctx, engineUUID); err != nil {
+	cfg := EngineConfig{}
+	if config != nil {
+		cfg = *config
+	}
+	cfg.TableName = tableName
+	config = &cfg
+
+	if err := be.abstract.OpenEngine(ctx, engineUUID, config); err != nil {
 		return nil, err
 	}
 
@@ -315,8 +448,38 @@ func (be Backend) OpenEngine(ctx context.Context, tableName string, engineID int
 	return &OpenedEngine{
 		engine: engine{
 			backend: be.abstract,
+			dupMgr:  be.dupMgr,
 			logger:  logger,
 			uuid:    engineUUID,
+			config:  config,
+		},
+		tableName: tableName,
+		ts:        oracle.ComposeTS(time.Now().Unix()*1000, 0),
+	}, nil
+}
+
+// ResumeEngine returns the already-open engine identified by tableName and
+// engineID, without calling AbstractBackend.OpenEngine again. Unlike pairing
+// UnsafeCloseEngine with a fresh OpenEngine, this preserves whatever chunk
+// offsets were recorded via OpenedEngine.RecordChunkCheckpoint before
+// Lightning was killed, so a chunk can resume from its last durable offset
+// (OpenedEngine.SeekWriteOffset) instead of being replayed from the start.
+func (be Backend) ResumeEngine(ctx context.Context, config *EngineConfig, tableName string, engineID int32) (*OpenedEngine, error) {
+	cfg := EngineConfig{}
+	if config != nil {
+		cfg = *config
+	}
+	cfg.TableName = tableName
+	config = &cfg
+	tag, engineUUID := MakeUUID(tableName, engineID)
+
+	return &OpenedEngine{
+		engine: engine{
+			backend: be.abstract,
+			dupMgr:  be.dupMgr,
+			logger:  makeLogger(tag, engineUUID),
+			uuid:    engineUUID,
+			config:  config,
 		},
 		tableName: tableName,
 		ts:        oracle.ComposeTS(time.Now().Unix()*1000, 0),
@@ -337,27 +500,96 @@ func (engine *OpenedEngine) Flush() error {
 	return engine.backend.FlushEngine(engine.uuid)
 }
 
-// WriteRows writes a collection of encoded rows into the engine.
-func (engine *OpenedEngine) WriteRows(ctx context.Context, columnNames []string, rows Rows) error {
-	var err error
+// UUID returns the engine's unique identifier.
+func (engine *OpenedEngine) UUID() uuid.UUID {
+	return engine.uuid
+}
+
+// UnsafeImportAndReset forces the backend to import the content already
+// written to this engine, then resets it to empty while keeping the engine
+// itself open for further writes. See Backend.UnsafeImportAndReset for the
+// caveats of calling this without closing the engine first.
+func (engine *OpenedEngine) UnsafeImportAndReset(ctx context.Context) error {
+	closedEngine := ClosedEngine{engine: engine.engine}
+	if err := closedEngine.Import(ctx); err != nil {
+		return err
+	}
+	return engine.backend.ResetEngine(ctx, engine.uuid)
+}
+
+// GetWrittenKeyRange returns the range of user keys already written to this
+// engine.
+func (engine *OpenedEngine) GetWrittenKeyRange() (startKey, endKey []byte, err error) {
+	return engine.backend.GetWrittenKeyRange(engine.uuid)
+}
+
+// SeekWriteOffset returns the byte offset inside chunkID up to which data
+// has already been durably written to this engine, so the caller can resume
+// the chunk instead of replaying it from the start.
+func (engine *OpenedEngine) SeekWriteOffset(chunkID string) (offset int64, found bool, err error) {
+	return engine.backend.SeekWriteOffset(engine.uuid, chunkID)
+}
+
+// RecordChunkCheckpoint persists the byte offset reached so far while
+// writing chunkID into this engine. The offset only becomes durable once
+// Flush is called.
+func (engine *OpenedEngine) RecordChunkCheckpoint(chunkID string, offset int64) error {
+	return engine.backend.RecordChunkCheckpoint(engine.uuid, chunkID, offset)
+}
+
+// WriteRows writes a collection of encoded rows read from chunkID up to
+// offset (the byte position reached in the chunk's source file) into the
+// engine. It is a thin wrapper around a single EngineWriter: the caller is
+// expected to call WriteRows from one goroutine per chunk, so each chunk
+// gets its own writer and chunks of the same engine never serialize on one
+// another. The returned ChunkFlushStatus lets the checkpoint subsystem
+// learn precisely when this chunk's rows have become durable in the local
+// engine, as opposed to merely batched in memory.
+//
+// If chunkID was already durably written up to offset or beyond by a
+// previous, killed Lightning process, WriteRows skips writing rows again
+// and reports the chunk as already synced, so the caller can resume a
+// chunk at the exact byte offset where that process left off.
+func (engine *OpenedEngine) WriteRows(ctx context.Context, chunkID string, offset int64, columnNames []string, rows Rows) (ChunkFlushStatus, error) {
+	lastOffset, found, err := engine.SeekWriteOffset(chunkID)
+	if err != nil {
+		return nil, err
+	}
+	if found && offset <= lastOffset {
+		return syncedChunkFlushStatus{}, nil
+	}
+
+	writer, err := engine.backend.LocalWriter(ctx, engine.uuid, 0)
+	if err != nil {
+		return nil, err
+	}
 
 outside:
 	for _, r := range rows.SplitIntoChunks(engine.backend.MaxChunkSize()) {
 		for i := 0; i < maxRetryTimes; i++ {
-			err = engine.backend.WriteRows(ctx, engine.uuid, engine.tableName, columnNames, engine.ts, r)
+			err = writer.AppendRows(ctx, engine.tableName, columnNames, engine.ts, r)
 			switch {
 			case err == nil:
 				continue outside
 			case common.IsRetryableError(err):
 				// retry next loop
 			default:
-				return err
+				_, _ = writer.Close(ctx)
+				return nil, err
 			}
 		}
-		return errors.Annotatef(err, "[%s] write rows reach max retry %d and still failed", engine.tableName, maxRetryTimes)
+		_, _ = writer.Close(ctx)
+		return nil, errors.Annotatef(err, "[%s] write rows reach max retry %d and still failed", engine.tableName, maxRetryTimes)
 	}
 
-	return nil
+	status, err := writer.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := engine.RecordChunkCheckpoint(chunkID, offset); err != nil {
+		return nil, err
+	}
+	return status, nil
 }
 
 // UnsafeCloseEngine closes the engine without first opening it.
@@ -365,9 +597,9 @@ outside:
 // (Open -> Write -> Close -> Import). This method should only be used when one
 // knows via other ways that the engine has already been opened, e.g. when
 // resuming from a checkpoint.
-func (be Backend) UnsafeCloseEngine(ctx context.Context, tableName string, engineID int32) (*ClosedEngine, error) {
+func (be Backend) UnsafeCloseEngine(ctx context.Context, config *EngineConfig, tableName string, engineID int32) (*ClosedEngine, error) {
 	tag, engineUUID := MakeUUID(tableName, engineID)
-	return be.UnsafeCloseEngineWithUUID(ctx, tag, engineUUID)
+	return be.UnsafeCloseEngineWithUUID(ctx, config, tag, engineUUID)
 }
 
 // UnsafeCloseEngineWithUUID closes the engine without first opening it.
@@ -375,14 +607,66 @@ func (be Backend) UnsafeCloseEngine(ctx context.Context, tableName string, engin
 // (Open -> Write -> Close -> Import). This method should only be used when one
 // knows via other ways that the engine has already been opened, e.g. when
 // resuming from a checkpoint.
-func (be Backend) UnsafeCloseEngineWithUUID(ctx context.Context, tag string, engineUUID uuid.UUID) (*ClosedEngine, error) {
+func (be Backend) UnsafeCloseEngineWithUUID(ctx context.Context, config *EngineConfig, tag string, engineUUID uuid.UUID) (*ClosedEngine, error) {
+	if config == nil {
+		config = &EngineConfig{}
+	}
 	return engine{
 		backend: be.abstract,
+		dupMgr:  be.dupMgr,
 		logger:  makeLogger(tag, engineUUID),
 		uuid:    engineUUID,
+		config:  config,
 	}.unsafeClose(ctx)
 }
 
+const (
+	// regionSplitSizeScaleFactor derives a scaled-up region split size from
+	// an engine's actual on-disk size: engineSize / regionSplitSizeScaleFactor.
+	regionSplitSizeScaleFactor = 16
+
+	// maxScaledRegionSplitSize bounds how large the scaled-up region split
+	// size may grow, regardless of the engine's on-disk size.
+	maxScaledRegionSplitSize = 4 * 1024 * 1024 * 1024 // 4 GiB
+)
+
+// regionSplitSizeAndKeys computes the region split size/keys to use when
+// importing this engine. The configured values (from the EngineConfig
+// passed at open time) are used as-is unless the engine's actual on-disk
+// size has grown to at least 4x the configured regionSplitSize, in which
+// case both values are scaled up proportionally to the engine size (capped
+// at maxScaledRegionSplitSize) so a very large engine produces
+// proportionally larger, fewer regions during pre-split, avoiding
+// unnecessary pressure on PD and TiKV.
+func (en engine) regionSplitSizeAndKeys() (regionSplitSize, regionSplitKeys int64) {
+	regionSplitSize, regionSplitKeys = en.config.RegionSplitSize, en.config.RegionSplitKeys
+	if regionSplitSize <= 0 {
+		return regionSplitSize, regionSplitKeys
+	}
+
+	var engineSize int64
+	for _, fileSize := range en.backend.EngineFileSizes() {
+		if fileSize.UUID == en.uuid {
+			engineSize = fileSize.Size
+			break
+		}
+	}
+	if engineSize < 4*regionSplitSize {
+		return regionSplitSize, regionSplitKeys
+	}
+
+	scaledSize := engineSize / regionSplitSizeScaleFactor
+	if scaledSize > maxScaledRegionSplitSize {
+		scaledSize = maxScaledRegionSplitSize
+	}
+	if scaledSize <= regionSplitSize {
+		return regionSplitSize, regionSplitKeys
+	}
+
+	scale := float64(scaledSize) / float64(regionSplitSize)
+	return scaledSize, int64(float64(regionSplitKeys) * scale)
+}
+
 func (en engine) unsafeClose(ctx context.Context) (*ClosedEngine, error) {
 	task := en.logger.Begin(zap.InfoLevel, "engine close")
 	err := en.backend.CloseEngine(ctx, en.uuid)
@@ -397,9 +681,28 @@ func (en engine) unsafeClose(ctx context.Context) (*ClosedEngine, error) {
 func (engine *ClosedEngine) Import(ctx context.Context) error {
 	var err error
 
+	regionSplitSize, regionSplitKeys := engine.regionSplitSizeAndKeys()
+
+	failpoint.Inject("AssertComputedRegionSplit", func(val failpoint.Value) {
+		expected := val.(string)
+		actual := fmt.Sprintf("%d,%d", regionSplitSize, regionSplitKeys)
+		if actual != expected {
+			panic(fmt.Sprintf("AssertComputedRegionSplit: expected %s, got %s", expected, actual))
+		}
+	})
+
 	for i := 0; i < maxRetryTimes; i++ {
 		task := engine.logger.With(zap.Int("retryCnt", i)).Begin(zap.InfoLevel, "import")
-		err = engine.backend.ImportEngine(ctx, engine.uuid)
+		err = engine.backend.ImportEngine(ctx, engine.uuid, regionSplitSize, regionSplitKeys)
+		if errors.Cause(err) == ErrDuplicateDetected && engine.dupMgr != nil {
+			if conflicts, collectErr := engine.backend.CollectDuplicateRows(ctx, engine.uuid); collectErr == nil {
+				if recordErr := engine.dupMgr.RecordConflicts(engine.uuid, conflicts); recordErr != nil {
+					engine.logger.Warn("failed to record duplicate conflicts", log.ShortError(recordErr))
+				}
+			} else {
+				engine.logger.Warn("failed to collect duplicate conflicts", log.ShortError(collectErr))
+			}
+		}
 		if !common.IsRetryableError(err) {
 			task.End(zap.ErrorLevel, err)
 			return err
@@ -423,6 +726,11 @@ func (engine *ClosedEngine) Logger() log.Logger {
 	return engine.logger
 }
 
+// UUID returns the engine's unique identifier.
+func (engine *ClosedEngine) UUID() uuid.UUID {
+	return engine.uuid
+}
+
 // Encoder encodes a row of SQL values into some opaque type which can be
 // consumed by OpenEngine.WriteEncoded.
 type Encoder interface {
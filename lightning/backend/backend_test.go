@@ -0,0 +1,150 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/failpoint"
+)
+
+// regionSplitMockBackend implements just enough of AbstractBackend to drive
+// ClosedEngine.Import: it reports a fixed on-disk engine size and records
+// whatever region split parameters it was asked to import with.
+type regionSplitMockBackend struct {
+	AbstractBackend
+	engineUUID uuid.UUID
+	engineSize int64
+
+	importedSize, importedKeys int64
+	importCalled               bool
+}
+
+func (m *regionSplitMockBackend) EngineFileSizes() []EngineFileSize {
+	return []EngineFileSize{{UUID: m.engineUUID, Size: m.engineSize}}
+}
+
+func (m *regionSplitMockBackend) RetryImportDelay() time.Duration {
+	return 0
+}
+
+func (m *regionSplitMockBackend) ImportEngine(ctx context.Context, engineUUID uuid.UUID, regionSplitSize, regionSplitKeys int64) error {
+	m.importCalled = true
+	m.importedSize = regionSplitSize
+	m.importedKeys = regionSplitKeys
+	return nil
+}
+
+func newTestClosedEngine(backend AbstractBackend, engineUUID uuid.UUID, config *EngineConfig) *ClosedEngine {
+	return &ClosedEngine{engine: engine{
+		backend: backend,
+		logger:  makeLogger("test", engineUUID),
+		uuid:    engineUUID,
+		config:  config,
+	}}
+}
+
+func TestRegionSplitSizeAndKeysScaling(t *testing.T) {
+	const mib = 1 << 20
+	const gib = 1 << 30
+
+	cases := []struct {
+		name       string
+		cfgSize    int64
+		cfgKeys    int64
+		engineSize int64
+		wantSize   int64
+		wantKeys   int64
+	}{
+		{
+			name:       "below 4x keeps configured values",
+			cfgSize:    mib,
+			cfgKeys:    10,
+			engineSize: 3 * mib,
+			wantSize:   mib,
+			wantKeys:   10,
+		},
+		{
+			name:       "at 4x but scaled size still below configured keeps configured values",
+			cfgSize:    mib,
+			cfgKeys:    10,
+			engineSize: 4 * mib,
+			wantSize:   mib,
+			wantKeys:   10,
+		},
+		{
+			name:       "comfortably past 4x scales proportionally",
+			cfgSize:    mib,
+			cfgKeys:    10,
+			engineSize: 64 * mib,
+			wantSize:   4 * mib,
+			wantKeys:   40,
+		},
+		{
+			name:       "very large engine clamps at the max scaled size",
+			cfgSize:    mib,
+			cfgKeys:    10,
+			engineSize: 200 * gib,
+			wantSize:   maxScaledRegionSplitSize,
+			wantKeys:   10 * (maxScaledRegionSplitSize / mib),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			engineUUID := uuid.New()
+			mock := &regionSplitMockBackend{engineUUID: engineUUID, engineSize: tc.engineSize}
+			en := engine{
+				backend: mock,
+				uuid:    engineUUID,
+				config:  &EngineConfig{RegionSplitSize: tc.cfgSize, RegionSplitKeys: tc.cfgKeys},
+			}
+
+			gotSize, gotKeys := en.regionSplitSizeAndKeys()
+			if gotSize != tc.wantSize || gotKeys != tc.wantKeys {
+				t.Fatalf("regionSplitSizeAndKeys() = (%d, %d), want (%d, %d)", gotSize, gotKeys, tc.wantSize, tc.wantKeys)
+			}
+		})
+	}
+}
+
+func TestClosedEngineImportPassesComputedRegionSplit(t *testing.T) {
+	require := func(cond bool, format string, args ...interface{}) {
+		if !cond {
+			t.Fatalf(format, args...)
+		}
+	}
+
+	const mib = 1 << 20
+	engineUUID := uuid.New()
+	mock := &regionSplitMockBackend{engineUUID: engineUUID, engineSize: 64 * mib}
+	closedEngine := newTestClosedEngine(mock, engineUUID, &EngineConfig{RegionSplitSize: mib, RegionSplitKeys: 10})
+
+	failpointPath := "github.com/pingcap/tidb-lightning/lightning/backend/AssertComputedRegionSplit"
+	require(
+		failpoint.Enable(failpointPath, fmt.Sprintf(`return("%d,%d")`, 4*mib, 40)) == nil,
+		"failed to enable failpoint",
+	)
+	defer failpoint.Disable(failpointPath)
+
+	err := closedEngine.Import(context.Background())
+	require(err == nil, "Import() returned unexpected error: %v", err)
+	require(mock.importCalled, "ImportEngine was never called")
+	require(mock.importedSize == 4*mib, "importedSize = %d, want %d", mock.importedSize, 4*mib)
+	require(mock.importedKeys == 40, "importedKeys = %d, want %d", mock.importedKeys, 40)
+}
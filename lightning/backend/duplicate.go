@@ -0,0 +1,131 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+)
+
+// ErrDuplicateDetected is returned by AbstractBackend.ImportEngine when the
+// backend notices duplicate index keys (byte-equal user keys with differing
+// values) while ingesting the engine's KV pairs. The engine itself is left
+// untouched; the caller may inspect the conflicts via Backend's duplicate
+// manager and decide whether to roll the engine back (CleanupEngine +
+// ResetEngine) or proceed with the import regardless.
+var ErrDuplicateDetected = errors.New("found duplicate key, the import of this engine has been aborted")
+
+// DuplicateConflict describes a single pair of KV entries sharing the same
+// unique index key but disagreeing on the row they point to.
+type DuplicateConflict struct {
+	Table  string
+	Index  string
+	KeyA   []byte
+	KeyB   []byte
+	RowIDs []int64
+}
+
+// DuplicateManager persists the duplicate conflicts collected while
+// importing an engine with duplicate detection enabled, so they can be
+// inspected and resolved after the fact. Implementations are expected to
+// keep the conflicts in a local KV store keyed by engine UUID, so that the
+// conflicts survive a Lightning restart.
+type DuplicateManager interface {
+	// RecordConflicts persists the conflicts found while importing engineUUID.
+	// Calling it again for the same engineUUID replaces the previous record.
+	RecordConflicts(engineUUID uuid.UUID, conflicts []DuplicateConflict) error
+
+	// Conflicts returns the conflicts recorded for engineUUID, or nil if none
+	// were recorded.
+	Conflicts(engineUUID uuid.UUID) ([]DuplicateConflict, error)
+
+	// Close releases any resource held by the manager.
+	Close() error
+}
+
+// memoryDuplicateManager is a DuplicateManager backed by an in-memory map.
+// It is only used when passed explicitly via WithDuplicateManager; a Backend
+// created without a manager has none.
+type memoryDuplicateManager struct {
+	mu        sync.Mutex
+	conflicts map[uuid.UUID][]DuplicateConflict
+}
+
+// NewMemoryDuplicateManager creates a DuplicateManager which keeps all
+// conflicts in memory for the lifetime of the process. It is mostly useful
+// for tests and for backends which do not have a local KV store of their
+// own.
+func NewMemoryDuplicateManager() DuplicateManager {
+	return &memoryDuplicateManager{conflicts: make(map[uuid.UUID][]DuplicateConflict)}
+}
+
+func (m *memoryDuplicateManager) RecordConflicts(engineUUID uuid.UUID, conflicts []DuplicateConflict) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conflicts[engineUUID] = conflicts
+	return nil
+}
+
+func (m *memoryDuplicateManager) Conflicts(engineUUID uuid.UUID) ([]DuplicateConflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conflicts[engineUUID], nil
+}
+
+func (m *memoryDuplicateManager) Close() error {
+	return nil
+}
+
+// IterDuplicateConflicts iterates over the conflicts recorded for engineUUID,
+// calling fn for each of them. Iteration stops as soon as fn returns false.
+// It is a no-op if the backend was created without a DuplicateManager.
+func (be Backend) IterDuplicateConflicts(engineUUID uuid.UUID, fn func(DuplicateConflict) bool) error {
+	if be.dupMgr == nil {
+		return nil
+	}
+	conflicts, err := be.dupMgr.Conflicts(engineUUID)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		if !fn(c) {
+			break
+		}
+	}
+	return nil
+}
+
+// ResolveDuplicateRows re-queries the target TiDB cluster for the rows
+// involved in the conflicts recorded for engineUUID, and records the
+// offending rows so the user can act on them later, e.g. via
+// `tidb-lightning-ctl`. It is a no-op if the backend was created without a
+// DuplicateManager or no conflicts were recorded for this engine.
+func (be Backend) ResolveDuplicateRows(ctx context.Context, engineUUID uuid.UUID) error {
+	if be.dupMgr == nil {
+		return nil
+	}
+	conflicts, err := be.dupMgr.Conflicts(engineUUID)
+	if err != nil {
+		return errors.Annotatef(err, "cannot read recorded conflicts for engine '%s'", engineUUID)
+	}
+	for _, c := range conflicts {
+		if err := be.abstract.ResolveDuplicateRows(ctx, c); err != nil {
+			return errors.Annotatef(err, "cannot resolve duplicate rows for index '%s'", c.Index)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ingestctx lets a caller embed the backend package's
+// Open->Write->Close->Import lifecycle inside an in-process job, such as a
+// DDL index-build task, without dealing with engine UUIDs directly.
+package ingestctx
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/table"
+
+	"github.com/pingcap/tidb-lightning/lightning/backend"
+)
+
+// diskQuotaWatermark is the local disk usage, in bytes, past which
+// FinishedWritingNeedImport reports that the caller should force an import
+// to free up space.
+const diskQuotaWatermark = 32 << 30 // 32 GiB
+
+// FlushMode controls how aggressively BackendCtx.Flush synchronizes and
+// imports the engines it manages.
+type FlushMode int
+
+const (
+	// FlushModeAuto flushes every managed engine, but only once their total
+	// on-disk size (DiskRoot) has reached the local disk quota watermark.
+	FlushModeAuto FlushMode = iota
+	// FlushModeForceFlush flushes every managed engine regardless of size.
+	FlushModeForceFlush
+	// FlushModeForceFlushAndImport flushes every managed engine and then
+	// imports it into the target, resetting it back to empty so writing can
+	// continue.
+	FlushModeForceFlushAndImport
+)
+
+type indexEngine struct {
+	engine   *backend.OpenedEngine
+	finished bool
+}
+
+// engineKey identifies one registered engine by the DDL job that owns it
+// and the index being built. TiDB assigns index IDs per-table (e.g. every
+// table's first index gets ID 1), so indexID alone is not unique across
+// jobs on different tables.
+type engineKey struct {
+	jobID   int64
+	indexID int64
+}
+
+// BackendCtx wraps a backend.Backend plus a registry of engines keyed by
+// (jobID, indexID), so an in-process caller can drive the
+// Open->Write->Close->Import lifecycle without touching engine UUIDs
+// directly.
+type BackendCtx struct {
+	mu      sync.Mutex
+	be      backend.Backend
+	engines map[engineKey]*indexEngine
+}
+
+// NewBackendCtx wraps be so it can be embedded inside an in-process job.
+func NewBackendCtx(be backend.Backend) *BackendCtx {
+	return &BackendCtx{
+		be:      be,
+		engines: make(map[engineKey]*indexEngine),
+	}
+}
+
+// Register opens one engine per entry of indexIDs for tableName and
+// associates each with jobID, returning the opened engines in the same
+// order as indexIDs. Calling Register again for an already-registered
+// (jobID, indexID) pair returns its existing engine instead of opening a
+// new one, unless that engine has already finished import, in which case a
+// fresh engine is opened in its place.
+func (bc *BackendCtx) Register(jobID int64, indexIDs []int64, tableName string) ([]*backend.OpenedEngine, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	openedEngines := make([]*backend.OpenedEngine, 0, len(indexIDs))
+	for _, indexID := range indexIDs {
+		key := engineKey{jobID: jobID, indexID: indexID}
+		if en, ok := bc.engines[key]; ok && !en.finished {
+			openedEngines = append(openedEngines, en.engine)
+			continue
+		}
+
+		cfg := &backend.EngineConfig{IsIndexEngine: true}
+		openedEngine, err := bc.be.OpenEngine(context.Background(), cfg, tableName, int32(indexID))
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot open engine for index %d", indexID)
+		}
+		bc.engines[key] = &indexEngine{engine: openedEngine}
+		openedEngines = append(openedEngines, openedEngine)
+	}
+	return openedEngines, nil
+}
+
+// UnregisterEngines discards every engine associated with jobID without
+// importing them. It should only be called after the corresponding engines
+// have been cleaned up, e.g. because the DDL job was cancelled.
+func (bc *BackendCtx) UnregisterEngines(jobID int64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for key := range bc.engines {
+		if key.jobID == jobID {
+			delete(bc.engines, key)
+		}
+	}
+}
+
+// FinishImport closes the engine registered for (jobID, indexID) and
+// imports it into the target. It may only be called once per (jobID,
+// indexID) pair; subsequent calls, or writes to the engine returned by
+// Register, return an error. If unique is true and the backend detects
+// duplicate keys, the offending rows of tbl are resolved and recorded via
+// the backend's duplicate manager instead of the import being retried.
+func (bc *BackendCtx) FinishImport(jobID, indexID int64, unique bool, tbl table.Table) error {
+	en, err := bc.takeEngine(jobID, indexID)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	closedEngine, err := en.engine.Close(ctx)
+	if err != nil {
+		return err
+	}
+
+	importErr := closedEngine.Import(ctx)
+	if errors.Cause(importErr) == backend.ErrDuplicateDetected {
+		if !unique {
+			return importErr
+		}
+		return errors.Annotatef(
+			bc.be.ResolveDuplicateRows(ctx, closedEngine.UUID()),
+			"cannot resolve duplicate rows of table '%s'", tbl.Meta().Name,
+		)
+	}
+	if importErr != nil {
+		return importErr
+	}
+
+	return closedEngine.Cleanup(ctx)
+}
+
+// takeEngine marks the engine registered for (jobID, indexID) as finished,
+// ensuring FinishImport can only run once per (jobID, indexID) pair.
+func (bc *BackendCtx) takeEngine(jobID, indexID int64) (*indexEngine, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	en, ok := bc.engines[engineKey{jobID: jobID, indexID: indexID}]
+	if !ok {
+		return nil, errors.Errorf("index %d of job %d is not registered", indexID, jobID)
+	}
+	if en.finished {
+		return nil, errors.Errorf("index %d of job %d has already finished import", indexID, jobID)
+	}
+	en.finished = true
+	return en, nil
+}
+
+// Flush synchronizes the write buffers of every managed engine which is
+// still open, according to mode. It returns whether any engine was flushed
+// and whether any engine was also imported.
+func (bc *BackendCtx) Flush(mode FlushMode) (flushed bool, imported bool, err error) {
+	bc.mu.Lock()
+	engines := make([]*indexEngine, 0, len(bc.engines))
+	for _, en := range bc.engines {
+		if !en.finished {
+			engines = append(engines, en)
+		}
+	}
+	bc.mu.Unlock()
+
+	if mode == FlushModeAuto && !bc.FinishedWritingNeedImport() {
+		return false, false, nil
+	}
+
+	for _, en := range engines {
+		if err = en.engine.Flush(); err != nil {
+			return flushed, imported, err
+		}
+		flushed = true
+
+		if mode == FlushModeForceFlushAndImport {
+			if err = en.engine.UnsafeImportAndReset(context.Background()); err != nil {
+				return flushed, imported, err
+			}
+			imported = true
+		}
+	}
+	return flushed, imported, nil
+}
+
+// FinishedWritingNeedImport reports whether the on-disk size of the engines
+// managed by this BackendCtx has grown past the disk quota watermark, and
+// the caller should force an import via Flush(FlushModeForceFlushAndImport).
+func (bc *BackendCtx) FinishedWritingNeedImport() bool {
+	return bc.DiskRoot() >= diskQuotaWatermark
+}
+
+// DiskRoot returns the total local disk size currently occupied by the
+// engines managed by this BackendCtx. Other engines known to the underlying
+// backend.Backend, e.g. ones belonging to the regular Lightning restore
+// path or another BackendCtx sharing the same backend, are not counted.
+func (bc *BackendCtx) DiskRoot() int64 {
+	bc.mu.Lock()
+	owned := make(map[uuid.UUID]struct{}, len(bc.engines))
+	for _, en := range bc.engines {
+		owned[en.engine.UUID()] = struct{}{}
+	}
+	bc.mu.Unlock()
+
+	var totalSize int64
+	for _, size := range bc.be.EngineFileSizes() {
+		if _, ok := owned[size.UUID]; ok {
+			totalSize += size.Size
+		}
+	}
+	return totalSize
+}
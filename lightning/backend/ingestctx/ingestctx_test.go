@@ -0,0 +1,135 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingestctx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pingcap/tidb-lightning/lightning/backend"
+)
+
+// mockBackend implements just enough of backend.AbstractBackend to drive
+// BackendCtx: every OpenEngine call succeeds (the engine UUID is derived by
+// backend.MakeUUID from the table name and index ID), and engine sizes are
+// whatever the test registers for them.
+type mockBackend struct {
+	backend.AbstractBackend
+	sizes map[uuid.UUID]int64
+}
+
+func (m *mockBackend) OpenEngine(ctx context.Context, engineUUID uuid.UUID, config *backend.EngineConfig) error {
+	return nil
+}
+
+func (m *mockBackend) CloseEngine(ctx context.Context, engineUUID uuid.UUID) error {
+	return nil
+}
+
+func (m *mockBackend) ImportEngine(ctx context.Context, engineUUID uuid.UUID, regionSplitSize, regionSplitKeys int64) error {
+	return nil
+}
+
+func (m *mockBackend) CleanupEngine(ctx context.Context, engineUUID uuid.UUID) error {
+	return nil
+}
+
+func (m *mockBackend) FlushEngine(engineUUID uuid.UUID) error {
+	return nil
+}
+
+func (m *mockBackend) RetryImportDelay() time.Duration {
+	return 0
+}
+
+func (m *mockBackend) EngineFileSizes() []backend.EngineFileSize {
+	sizes := make([]backend.EngineFileSize, 0, len(m.sizes))
+	for id, size := range m.sizes {
+		sizes = append(sizes, backend.EngineFileSize{UUID: id, Size: size})
+	}
+	return sizes
+}
+
+func newTestBackendCtx() (*BackendCtx, *mockBackend) {
+	mock := &mockBackend{sizes: make(map[uuid.UUID]int64)}
+	return NewBackendCtx(backend.MakeBackend(mock)), mock
+}
+
+func TestRegisterDoesNotCollideAcrossJobsWithSameIndexID(t *testing.T) {
+	bc, _ := newTestBackendCtx()
+
+	jobAEngines, err := bc.Register(1, []int64{1}, "`test`.`a`")
+	if err != nil {
+		t.Fatalf("Register() for job 1 returned unexpected error: %v", err)
+	}
+
+	if err := bc.FinishImport(1, 1, false, nil); err != nil {
+		t.Fatalf("FinishImport() for job 1 returned unexpected error: %v", err)
+	}
+
+	jobBEngines, err := bc.Register(2, []int64{1}, "`test`.`b`")
+	if err != nil {
+		t.Fatalf("Register() for job 2 returned unexpected error: %v", err)
+	}
+
+	if jobAEngines[0].UUID() == jobBEngines[0].UUID() {
+		t.Fatalf("job 2 was handed job 1's already-finished engine for index 1")
+	}
+
+	if err := bc.FinishImport(2, 1, false, nil); err != nil {
+		t.Fatalf("FinishImport() for job 2 returned unexpected error: %v", err)
+	}
+}
+
+func TestFinishImportIsScopedToItsOwnJob(t *testing.T) {
+	bc, _ := newTestBackendCtx()
+
+	if _, err := bc.Register(1, []int64{1}, "`test`.`a`"); err != nil {
+		t.Fatalf("Register() for job 1 returned unexpected error: %v", err)
+	}
+	if _, err := bc.Register(2, []int64{1}, "`test`.`b`"); err != nil {
+		t.Fatalf("Register() for job 2 returned unexpected error: %v", err)
+	}
+
+	if err := bc.FinishImport(1, 1, false, nil); err != nil {
+		t.Fatalf("FinishImport() for job 1 returned unexpected error: %v", err)
+	}
+
+	// Job 2's engine for the same indexID must still be importable.
+	if err := bc.FinishImport(2, 1, false, nil); err != nil {
+		t.Fatalf("FinishImport() for job 2 returned unexpected error: %v", err)
+	}
+}
+
+func TestDiskRootOnlyCountsOwnEngines(t *testing.T) {
+	bc, mock := newTestBackendCtx()
+
+	engines, err := bc.Register(1, []int64{1, 2}, "`test`.`a`")
+	if err != nil {
+		t.Fatalf("Register() returned unexpected error: %v", err)
+	}
+	mock.sizes[engines[0].UUID()] = 10 << 20
+	mock.sizes[engines[1].UUID()] = 20 << 20
+
+	// An engine belonging to neither this BackendCtx nor this job should not
+	// be counted, e.g. one opened directly on the shared backend.Backend.
+	mock.sizes[uuid.New()] = 1 << 40
+
+	if got, want := bc.DiskRoot(), int64(30<<20); got != want {
+		t.Fatalf("DiskRoot() = %d, want %d", got, want)
+	}
+}
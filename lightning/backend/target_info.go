@@ -0,0 +1,124 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+// minTiDBVersion is the lowest TiDB version this backend is known to import
+// into correctly.
+var minTiDBVersion = [3]int{4, 0, 0}
+
+// CheckCtx bundles the information TargetInfoGetter.CheckRequirements needs
+// to validate the target TiDB cluster against the tables about to be
+// imported.
+type CheckCtx struct {
+	// Tables holds the models of every table this import will touch.
+	Tables []*model.TableInfo
+}
+
+// TargetInfoGetter retrieves information about the target TiDB cluster which
+// does not depend on any particular backend implementation: the cluster's
+// version/capability requirements, and the schema of tables already created
+// there. It is split out of AbstractBackend so that callers with no TiDB
+// HTTP endpoint to talk to (e.g. an in-process DDL job) can supply their own
+// implementation instead of stubbing out methods they don't need.
+type TargetInfoGetter interface {
+	// CheckRequirements performs the check whether the target TiDB cluster
+	// satisfies the version and capability requirements of this import.
+	CheckRequirements(ctx context.Context, checkCtx *CheckCtx) error
+
+	// FetchRemoteTableModels obtains the models of all tables given the
+	// schema name. See AbstractBackend.FetchRemoteTableModels for the
+	// fields which must be filled in.
+	FetchRemoteTableModels(ctx context.Context, schemaName string) ([]*model.TableInfo, error)
+}
+
+// tlsTargetInfoGetter is the default TargetInfoGetter, talking to the target
+// cluster's TiDB status port over HTTPS.
+type tlsTargetInfoGetter struct {
+	tls *common.TLS
+}
+
+// NewTargetInfoGetter creates a TargetInfoGetter which talks to the target
+// TiDB cluster through tls.
+func NewTargetInfoGetter(tls *common.TLS) TargetInfoGetter {
+	return &tlsTargetInfoGetter{tls: tls}
+}
+
+func (g *tlsTargetInfoGetter) CheckRequirements(ctx context.Context, checkCtx *CheckCtx) error {
+	var versions []string
+	if err := g.tls.GetJSON(ctx, "/tidb/versions", &versions); err != nil {
+		return errors.Annotate(err, "cannot fetch target cluster version")
+	}
+	for _, version := range versions {
+		if err := checkTiDBVersion(version); err != nil {
+			return err
+		}
+	}
+
+	if checkCtx != nil {
+		for _, tbl := range checkCtx.Tables {
+			if tbl.State != model.StatePublic {
+				return errors.Errorf("table '%s' is not public yet, cannot import into it", tbl.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkTiDBVersion parses a "v4.0.0-beta-xxxxx" style version string as
+// reported by the /tidb/versions endpoint, and verifies it is not older
+// than minTiDBVersion.
+func checkTiDBVersion(version string) error {
+	trimmed := strings.TrimPrefix(version, "v")
+	if idx := strings.IndexAny(trimmed, "-+"); idx >= 0 {
+		trimmed = trimmed[:idx]
+	}
+
+	parts := strings.SplitN(trimmed, ".", 3)
+	var got [3]int
+	for i := 0; i < len(parts) && i < 3; i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return errors.Errorf("cannot parse target cluster version '%s'", version)
+		}
+		got[i] = n
+	}
+
+	for i := 0; i < 3; i++ {
+		if got[i] != minTiDBVersion[i] {
+			if got[i] < minTiDBVersion[i] {
+				return errors.Errorf(
+					"target cluster version '%s' is older than the minimum supported version %d.%d.%d",
+					version, minTiDBVersion[0], minTiDBVersion[1], minTiDBVersion[2],
+				)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (g *tlsTargetInfoGetter) FetchRemoteTableModels(ctx context.Context, schemaName string) ([]*model.TableInfo, error) {
+	return fetchRemoteTableModelsFromTLS(ctx, g.tls, schemaName)
+}
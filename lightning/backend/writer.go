@@ -0,0 +1,55 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import "context"
+
+// ChunkFlushStatus reports whether the rows appended through an EngineWriter
+// have been durably written to their target engine. The checkpoint
+// subsystem polls Flushed before marking the corresponding chunk as done, so
+// that a kill-9 followed by a resume never loses data which was believed to
+// be durable.
+type ChunkFlushStatus interface {
+	// Flushed returns whether all rows written through the EngineWriter that
+	// produced this status have reached the target engine.
+	Flushed() bool
+}
+
+// syncedChunkFlushStatus is a ChunkFlushStatus which is always durable. It
+// is returned by OpenedEngine.WriteRows when a chunk was found to already
+// be written up to the requested offset, so there is nothing left to flush.
+type syncedChunkFlushStatus struct{}
+
+func (syncedChunkFlushStatus) Flushed() bool {
+	return true
+}
+
+// EngineWriter streams rows into a single engine. It is obtained once per
+// chunk-writing goroutine via Backend.LocalWriter and reused for every batch
+// belonging to that chunk, so concurrent chunks of the same engine do not
+// need to serialize through a single shared method on the backend.
+type EngineWriter interface {
+	// AppendRows appends a batch of encoded rows into the writer's target
+	// engine.
+	AppendRows(ctx context.Context, tableName string, columnNames []string, commitTS uint64, rows Rows) error
+
+	// IsSynced returns whether all rows appended so far have reached the
+	// target engine, i.e. nothing is left buffered in memory.
+	IsSynced() bool
+
+	// Close flushes any rows still buffered in memory and returns a
+	// ChunkFlushStatus that the checkpoint subsystem can poll to learn when
+	// the chunk has become durable.
+	Close(ctx context.Context) (ChunkFlushStatus, error)
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type fakeRows struct{}
+
+func (fakeRows) SplitIntoChunks(splitSize int) []Rows {
+	return []Rows{fakeRows{}}
+}
+
+func (fakeRows) Clear() Rows {
+	return fakeRows{}
+}
+
+// fakeEngineWriter is a trivial EngineWriter which always succeeds and
+// counts how many batches it was asked to append.
+type fakeEngineWriter struct {
+	appended int
+	closed   bool
+}
+
+func (w *fakeEngineWriter) AppendRows(ctx context.Context, tableName string, columnNames []string, commitTS uint64, rows Rows) error {
+	w.appended++
+	return nil
+}
+
+func (w *fakeEngineWriter) IsSynced() bool {
+	return w.closed
+}
+
+func (w *fakeEngineWriter) Close(ctx context.Context) (ChunkFlushStatus, error) {
+	w.closed = true
+	return syncedChunkFlushStatus{}, nil
+}
+
+// writeRowsMockBackend implements just enough of AbstractBackend to drive
+// OpenedEngine.WriteRows: a fixed chunk-checkpoint offset and a writer whose
+// calls are counted.
+type writeRowsMockBackend struct {
+	AbstractBackend
+	writer *fakeEngineWriter
+
+	seekOffset int64
+	seekFound  bool
+
+	recordedChunkID string
+	recordedOffset  int64
+}
+
+func (m *writeRowsMockBackend) MaxChunkSize() int {
+	return 1 << 20
+}
+
+func (m *writeRowsMockBackend) LocalWriter(ctx context.Context, engineUUID uuid.UUID, maxCacheSize int64) (EngineWriter, error) {
+	return m.writer, nil
+}
+
+func (m *writeRowsMockBackend) SeekWriteOffset(engineUUID uuid.UUID, chunkID string) (int64, bool, error) {
+	return m.seekOffset, m.seekFound, nil
+}
+
+func (m *writeRowsMockBackend) RecordChunkCheckpoint(engineUUID uuid.UUID, chunkID string, offset int64) error {
+	m.recordedChunkID = chunkID
+	m.recordedOffset = offset
+	return nil
+}
+
+func newTestOpenedEngine(backend AbstractBackend) *OpenedEngine {
+	engineUUID := uuid.New()
+	return &OpenedEngine{
+		engine: engine{
+			backend: backend,
+			logger:  makeLogger("test", engineUUID),
+			uuid:    engineUUID,
+			config:  &EngineConfig{},
+		},
+		tableName: "t",
+	}
+}
+
+func TestWriteRowsSkipsChunkAlreadyWrittenPastOffset(t *testing.T) {
+	writer := &fakeEngineWriter{}
+	mock := &writeRowsMockBackend{writer: writer, seekOffset: 100, seekFound: true}
+	openedEngine := newTestOpenedEngine(mock)
+
+	status, err := openedEngine.WriteRows(context.Background(), "chunk-1", 50, []string{"a"}, fakeRows{})
+	if err != nil {
+		t.Fatalf("WriteRows() returned unexpected error: %v", err)
+	}
+	if !status.Flushed() {
+		t.Fatalf("status.Flushed() = false, want true for an already-durable chunk")
+	}
+	if writer.appended != 0 {
+		t.Fatalf("AppendRows was called %d times, want 0 since the chunk was already durable", writer.appended)
+	}
+}
+
+func TestWriteRowsRecordsCheckpointAfterWriting(t *testing.T) {
+	writer := &fakeEngineWriter{}
+	mock := &writeRowsMockBackend{writer: writer, seekOffset: 10, seekFound: true}
+	openedEngine := newTestOpenedEngine(mock)
+
+	status, err := openedEngine.WriteRows(context.Background(), "chunk-1", 200, []string{"a"}, fakeRows{})
+	if err != nil {
+		t.Fatalf("WriteRows() returned unexpected error: %v", err)
+	}
+	if !status.Flushed() {
+		t.Fatalf("status.Flushed() = false, want true after a successful write")
+	}
+	if writer.appended == 0 {
+		t.Fatalf("AppendRows was never called")
+	}
+	if mock.recordedChunkID != "chunk-1" || mock.recordedOffset != 200 {
+		t.Fatalf("RecordChunkCheckpoint recorded (%q, %d), want (%q, %d)",
+			mock.recordedChunkID, mock.recordedOffset, "chunk-1", 200)
+	}
+}